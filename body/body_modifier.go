@@ -19,8 +19,13 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"sync"
+	"text/template"
 
 	"github.com/google/martian"
 	"github.com/google/martian/parse"
@@ -32,21 +37,150 @@ func init() {
 
 // Modifier substitutes the body on an HTTP response.
 type Modifier struct {
-	contentType string
-	body        []byte
+	contentType   string
+	source        bodySource
+	tmpl          *template.Template
+	scope         []parse.ModifierType
+	skipRoundTrip *bool
+	encoding      string
+	encMu         sync.Mutex
+	encCache      map[string][]byte
+}
+
+// inScope reports whether t is one of the phases this Modifier acts on. A
+// Modifier with no scope set, as returned by the New* constructors, acts on
+// responses only.
+func (m *Modifier) inScope(t parse.ModifierType) bool {
+	if len(m.scope) == 0 {
+		return t == parse.Response
+	}
+	for _, s := range m.scope {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+// bodySource supplies the bytes of a replacement body. Implementations must
+// support being opened more than once so that a single Modifier can be
+// reused across repeated requests, each time handing back a fresh reader.
+type bodySource interface {
+	// open returns a reader for the body along with its length in bytes.
+	// The caller is responsible for closing the returned reader.
+	open() (io.ReadCloser, int64, error)
+}
+
+// bytesSource is a bodySource backed by an in-memory byte slice.
+type bytesSource struct {
+	body []byte
+}
+
+func (s *bytesSource) open() (io.ReadCloser, int64, error) {
+	return ioutil.NopCloser(bytes.NewReader(s.body)), int64(len(s.body)), nil
+}
+
+// fileSource is a bodySource backed by a file on disk, reopened on every
+// call so that large fixtures (video, binary test data) never have to be
+// held in memory.
+type fileSource struct {
+	path string
+}
+
+func (s *fileSource) open() (io.ReadCloser, int64, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+
+	return f, fi.Size(), nil
+}
+
+// urlSource is a bodySource that streams its content from an upstream URL,
+// re-fetching it on every open so repeated requests each see a fresh body.
+type urlSource struct {
+	url    string
+	client *http.Client
+}
+
+func (s *urlSource) open() (io.ReadCloser, int64, error) {
+	res, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, 0, fmt.Errorf("body: fetching %s: got status %d", s.url, res.StatusCode)
+	}
+
+	return res.Body, res.ContentLength, nil
 }
 
 type modifierJSON struct {
-	ContentType string               `json:"contentType"`
-	Body        string               `json:"body"` // Body is expected to be a Base64 encoded string.
-	Scope       []parse.ModifierType `json:"scope"`
+	ContentType   string               `json:"contentType"`
+	Body          string               `json:"body"`          // Body is expected to be a Base64 encoded string.
+	BodyFile      string               `json:"bodyFile"`      // BodyFile is a path to a file to stream the body from.
+	BodyURL       string               `json:"bodyURL"`       // BodyURL is a URL to stream the body from.
+	Template      bool                 `json:"template"`      // Template parses body as a text/template executed per-response.
+	SkipRoundTrip *bool                `json:"skipRoundTrip"` // SkipRoundTrip overrides the default scope-based roundtrip behavior.
+	Encoding      string               `json:"encoding"`      // Encoding is "gzip", "deflate", "br", "identity" or "auto".
+	Scope         []parse.ModifierType `json:"scope"`
 }
 
-// NewModifier constructs and returns a body.Modifier.
+// NewModifier constructs and returns a body.Modifier that replaces the body
+// with the in-memory byte slice b.
 func NewModifier(b []byte, contentType string) (*Modifier, error) {
 	return &Modifier{
 		contentType: contentType,
-		body:        b,
+		source:      &bytesSource{body: b},
+	}, nil
+}
+
+// NewTemplateModifier constructs and returns a body.Modifier that parses b
+// as a text/template and executes it against the request and response on
+// every ModifyResponse call, rather than serving a fixed body.
+func NewTemplateModifier(b []byte, contentType string) (*Modifier, error) {
+	tmpl, err := template.New("body").Funcs(templateFuncs).Parse(string(b))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Modifier{
+		contentType: contentType,
+		tmpl:        tmpl,
+	}, nil
+}
+
+// NewFileModifier constructs and returns a body.Modifier that streams its
+// replacement body from the file at path on every request, rather than
+// holding it in memory for the lifetime of the modifier.
+func NewFileModifier(path, contentType string) (*Modifier, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	return &Modifier{
+		contentType: contentType,
+		source:      &fileSource{path: path},
+	}, nil
+}
+
+// NewURLModifier constructs and returns a body.Modifier that streams its
+// replacement body from url on every request.
+func NewURLModifier(url, contentType string) (*Modifier, error) {
+	return &Modifier{
+		contentType: contentType,
+		source: &urlSource{
+			url:    url,
+			client: http.DefaultClient,
+		},
 	}, nil
 }
 
@@ -59,44 +193,208 @@ func NewModifier(b []byte, contentType string) (*Modifier, error) {
 //   "contentType": "text/plain",
 //   "body": "c29tZSBkYXRhIHdpdGggACBhbmQg77u/" // Base64 encoded body
 // }
+//
+// body, bodyFile and bodyURL are mutually exclusive; bodyFile streams the
+// replacement body from disk and bodyURL streams it from an upstream URL,
+// which avoids buffering large fixtures in memory.
 func modifierFromJSON(b []byte) (*parse.Result, error) {
 	msg := &modifierJSON{}
 	if err := json.Unmarshal(b, msg); err != nil {
 		return nil, err
 	}
 
-	body, err := base64.StdEncoding.DecodeString(msg.Body)
-	if err != nil {
-		return nil, err
+	set := 0
+	for _, s := range []string{msg.Body, msg.BodyFile, msg.BodyURL} {
+		if s != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, fmt.Errorf("body: body, bodyFile and bodyURL are mutually exclusive")
 	}
 
-	mod, err := NewModifier(body, msg.ContentType)
+	var mod *Modifier
+	var err error
+	switch {
+	case msg.BodyFile != "":
+		mod, err = NewFileModifier(msg.BodyFile, msg.ContentType)
+	case msg.BodyURL != "":
+		mod, err = NewURLModifier(msg.BodyURL, msg.ContentType)
+	default:
+		var body []byte
+		body, err = base64.StdEncoding.DecodeString(msg.Body)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Template {
+			mod, err = NewTemplateModifier(body, msg.ContentType)
+		} else {
+			mod, err = NewModifier(body, msg.ContentType)
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	if !isSupportedEncoding(msg.Encoding) {
+		return nil, fmt.Errorf("body: unsupported encoding %q", msg.Encoding)
+	}
+
+	mod.scope = msg.Scope
+	mod.skipRoundTrip = msg.SkipRoundTrip
+	mod.encoding = msg.Encoding
+
 	return parse.NewResult(mod, msg.Scope)
 }
 
-// ModifyRequest signals to the proxy to skip the roundtrip.
+// ModifyRequest rewrites the outgoing request body when "request" is in
+// scope, setting Content-Type and clearing any Content-Encoding and
+// chunked Transfer-Encoding that no longer apply to the replacement body.
+//
+// By default the roundtrip is only skipped when the scope is
+// response-only, since a request-scoped rewrite is meant to actually be
+// sent upstream. skipRoundTrip in the JSON config overrides this.
+//
+// Template-mode modifiers never rewrite the request body, since a
+// template is rendered against the final response.
 func (m *Modifier) ModifyRequest(ctx *martian.Context, req *http.Request) error {
-	ctx.SkipRoundTrip = true
+	if m.inScope(parse.Request) && m.tmpl == nil {
+		body, n, err := m.source.open()
+		if err != nil {
+			return err
+		}
+
+		req.Body = body
+		req.ContentLength = n
+		req.Header.Set("Content-Type", m.contentType)
+		req.Header.Del("Content-Encoding")
+		req.TransferEncoding = nil
+	}
+
+	switch {
+	case m.skipRoundTrip != nil:
+		ctx.SkipRoundTrip = *m.skipRoundTrip
+	default:
+		ctx.SkipRoundTrip = m.inScope(parse.Response) && !m.inScope(parse.Request)
+	}
 
 	return nil
 }
 
-// ModifyResponse sets the Content-Type header and overrides the response body.
+// ModifyResponse sets the Content-Type header and overrides the response
+// body when "response" is in scope. If an encoding was configured, the
+// body is compressed (or, for "auto", compressed with whatever the
+// request's Accept-Encoding header supports best) before being served.
 func (m *Modifier) ModifyResponse(ctx *martian.Context, res *http.Response) error {
+	if !m.inScope(parse.Response) {
+		return nil
+	}
+
 	// Replace the existing body, close it first.
 	res.Body.Close()
 
 	res.Header.Set("Content-Type", m.contentType)
 
-	// Reset the Content-Encoding since we know that the new body isn't encoded.
+	// Reset the Content-Encoding; it is recomputed below if needed.
 	res.Header.Del("Content-Encoding")
 
-	res.ContentLength = int64(len(m.body))
-	res.Body = ioutil.NopCloser(bytes.NewReader(m.body))
+	if m.encoding == "" {
+		if m.tmpl != nil {
+			return m.serveTemplate(res)
+		}
+
+		body, n, err := m.source.open()
+		if err != nil {
+			return err
+		}
+
+		res.ContentLength = n
+		res.Body = body
+
+		return nil
+	}
+
+	raw, cacheable, err := m.rawBody(res)
+	if err != nil {
+		return err
+	}
+
+	enc := m.encoding
+	if enc == encodingAuto {
+		enc = negotiateEncoding(res.Request.Header.Get("Accept-Encoding"))
+	}
+
+	out, err := m.encode(raw, enc, cacheable)
+	if err != nil {
+		return err
+	}
+
+	if enc != encodingIdentity {
+		res.Header.Set("Content-Encoding", enc)
+	}
+
+	res.ContentLength = int64(len(out))
+	res.Body = ioutil.NopCloser(bytes.NewReader(out))
+
+	return nil
+}
+
+// rawBody reads the full replacement body for res, along with whether that
+// body is safe to cache by encoding (true for an in-memory bytesSource,
+// false for sources whose content may differ between calls).
+func (m *Modifier) rawBody(res *http.Response) ([]byte, bool, error) {
+	if m.tmpl != nil {
+		raw, err := m.renderTemplate(res)
+		return raw, false, err
+	}
+
+	body, _, err := m.source.open()
+	if err != nil {
+		return nil, false, err
+	}
+	defer body.Close()
+
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	_, cacheable := m.source.(*bytesSource)
+
+	return raw, cacheable, nil
+}
+
+// renderTemplate executes m.tmpl against res and returns the rendered body.
+func (m *Modifier) renderTemplate(res *http.Response) ([]byte, error) {
+	var buf bytes.Buffer
+	ctx := &templateContext{
+		Request: templateRequest{
+			Method: res.Request.Method,
+			URL:    res.Request.URL,
+			Header: res.Request.Header,
+		},
+		Response: templateResponse{
+			StatusCode: res.StatusCode,
+			Header:     res.Header,
+		},
+	}
+	if err := m.tmpl.Execute(&buf, ctx); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// serveTemplate renders m.tmpl and sets it as res.Body, used when no
+// encoding is configured so the rendered bytes can be streamed directly.
+func (m *Modifier) serveTemplate(res *http.Response) error {
+	raw, err := m.renderTemplate(res)
+	if err != nil {
+		return err
+	}
+
+	res.ContentLength = int64(len(raw))
+	res.Body = ioutil.NopCloser(bytes.NewReader(raw))
 
 	return nil
 }
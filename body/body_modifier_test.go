@@ -0,0 +1,127 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package body
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/martian"
+	"github.com/google/martian/parse"
+)
+
+func boolPtr(b bool) *bool { return &b }
+
+func TestModifyRequestScopeCombinations(t *testing.T) {
+	tests := []struct {
+		name              string
+		scope             []parse.ModifierType
+		skipRoundTrip     *bool
+		wantSkipRoundTrip bool
+		wantBodyRewritten bool
+	}{
+		{
+			name:              "response only",
+			scope:             []parse.ModifierType{parse.Response},
+			wantSkipRoundTrip: true,
+			wantBodyRewritten: false,
+		},
+		{
+			name:              "request only",
+			scope:             []parse.ModifierType{parse.Request},
+			wantSkipRoundTrip: false,
+			wantBodyRewritten: true,
+		},
+		{
+			name:              "request and response",
+			scope:             []parse.ModifierType{parse.Request, parse.Response},
+			wantSkipRoundTrip: false,
+			wantBodyRewritten: true,
+		},
+		{
+			name:              "explicit skipRoundTrip overrides scope",
+			scope:             []parse.ModifierType{parse.Request},
+			skipRoundTrip:     boolPtr(true),
+			wantSkipRoundTrip: true,
+			wantBodyRewritten: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mod, err := NewModifier([]byte("replaced"), "text/plain")
+			if err != nil {
+				t.Fatalf("NewModifier(): got error %v, want no error", err)
+			}
+			mod.scope = tt.scope
+			mod.skipRoundTrip = tt.skipRoundTrip
+
+			req, err := http.NewRequest("GET", "http://example.com", strings.NewReader("original"))
+			if err != nil {
+				t.Fatalf("http.NewRequest(): got error %v, want no error", err)
+			}
+
+			ctx := &martian.Context{}
+			if err := mod.ModifyRequest(ctx, req); err != nil {
+				t.Fatalf("ModifyRequest(): got error %v, want no error", err)
+			}
+
+			if ctx.SkipRoundTrip != tt.wantSkipRoundTrip {
+				t.Errorf("ctx.SkipRoundTrip = %v, want %v", ctx.SkipRoundTrip, tt.wantSkipRoundTrip)
+			}
+
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				t.Fatalf("ioutil.ReadAll(req.Body): got error %v, want no error", err)
+			}
+
+			gotRewritten := string(body) == "replaced"
+			if gotRewritten != tt.wantBodyRewritten {
+				t.Errorf("request body rewritten = %v (body: %q), want %v", gotRewritten, body, tt.wantBodyRewritten)
+			}
+		})
+	}
+}
+
+// TestModifyRequestTemplateScopeDoesNotPanic covers a template-mode
+// Modifier scoped to both request and response: ModifyRequest must not
+// dereference the (nil, for template mode) bodySource.
+func TestModifyRequestTemplateScopeDoesNotPanic(t *testing.T) {
+	mod, err := NewTemplateModifier([]byte("{{.Request.Method}}"), "text/plain")
+	if err != nil {
+		t.Fatalf("NewTemplateModifier(): got error %v, want no error", err)
+	}
+	mod.scope = []parse.ModifierType{parse.Request, parse.Response}
+
+	req, err := http.NewRequest("GET", "http://example.com", strings.NewReader("original"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got error %v, want no error", err)
+	}
+
+	ctx := &martian.Context{}
+	if err := mod.ModifyRequest(ctx, req); err != nil {
+		t.Fatalf("ModifyRequest(): got error %v, want no error", err)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(req.Body): got error %v, want no error", err)
+	}
+	if string(body) != "original" {
+		t.Errorf("request body = %q, want %q; template-mode modifiers don't rewrite requests", body, "original")
+	}
+}
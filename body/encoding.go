@@ -0,0 +1,124 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package body
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Supported values for modifierJSON.Encoding.
+const (
+	encodingGzip     = "gzip"
+	encodingDeflate  = "deflate"
+	encodingBrotli   = "br"
+	encodingIdentity = "identity"
+	encodingAuto     = "auto"
+)
+
+// isSupportedEncoding reports whether e is a value modifierJSON.Encoding
+// accepts; the empty string means encoding is left untouched.
+func isSupportedEncoding(e string) bool {
+	switch e {
+	case "", encodingGzip, encodingDeflate, encodingBrotli, encodingIdentity, encodingAuto:
+		return true
+	}
+	return false
+}
+
+// compress encodes body with enc, returning it unchanged for "identity".
+func compress(body []byte, enc string) ([]byte, error) {
+	if enc == "" || enc == encodingIdentity {
+		return body, nil
+	}
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+
+	switch enc {
+	case encodingGzip:
+		w = gzip.NewWriter(&buf)
+	case encodingDeflate:
+		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		w = fw
+	case encodingBrotli:
+		w = brotli.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("body: unsupported encoding %q", enc)
+	}
+
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// negotiateEncoding picks the best encoding this package can produce from a
+// client's Accept-Encoding header, preferring br, then gzip, then deflate.
+// It does not parse q-values; any mention of a supported encoding is
+// treated as acceptable.
+func negotiateEncoding(acceptEncoding string) string {
+	for _, enc := range []string{encodingBrotli, encodingGzip, encodingDeflate} {
+		if strings.Contains(acceptEncoding, enc) {
+			return enc
+		}
+	}
+	return encodingIdentity
+}
+
+// encode returns body compressed with enc, consulting and populating
+// m.encCache when cacheable is true (i.e. the body is known not to change
+// between calls, as with an in-memory bytesSource).
+func (m *Modifier) encode(body []byte, enc string, cacheable bool) ([]byte, error) {
+	if enc == "" || enc == encodingIdentity {
+		return body, nil
+	}
+
+	if cacheable {
+		m.encMu.Lock()
+		defer m.encMu.Unlock()
+
+		if b, ok := m.encCache[enc]; ok {
+			return b, nil
+		}
+	}
+
+	out, err := compress(body, enc)
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheable {
+		if m.encCache == nil {
+			m.encCache = make(map[string][]byte)
+		}
+		m.encCache[enc] = out
+	}
+
+	return out, nil
+}
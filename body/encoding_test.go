@@ -0,0 +1,234 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package body
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/google/martian"
+	"github.com/google/martian/parse"
+)
+
+// decompress reverses compress for test assertions; enc "" and "identity"
+// are passed through unchanged.
+func decompress(t *testing.T, enc string, body []byte) []byte {
+	t.Helper()
+
+	switch enc {
+	case encodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("gzip.NewReader(): got error %v, want no error", err)
+		}
+		defer r.Close()
+
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ioutil.ReadAll(): got error %v, want no error", err)
+		}
+		return out
+	case encodingDeflate:
+		r := flate.NewReader(bytes.NewReader(body))
+		defer r.Close()
+
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ioutil.ReadAll(): got error %v, want no error", err)
+		}
+		return out
+	case encodingBrotli:
+		out, err := ioutil.ReadAll(brotli.NewReader(bytes.NewReader(body)))
+		if err != nil {
+			t.Fatalf("ioutil.ReadAll(): got error %v, want no error", err)
+		}
+		return out
+	default:
+		return body
+	}
+}
+
+func TestModifyResponseEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		wantHdr  string
+	}{
+		{name: "gzip", encoding: encodingGzip, wantHdr: encodingGzip},
+		{name: "deflate", encoding: encodingDeflate, wantHdr: encodingDeflate},
+		{name: "brotli", encoding: encodingBrotli, wantHdr: encodingBrotli},
+		{name: "identity", encoding: encodingIdentity, wantHdr: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mod, err := NewModifier([]byte("hello world"), "text/plain")
+			if err != nil {
+				t.Fatalf("NewModifier(): got error %v, want no error", err)
+			}
+			mod.scope = []parse.ModifierType{parse.Response}
+			mod.encoding = tt.encoding
+
+			req, err := http.NewRequest("GET", "http://example.com", nil)
+			if err != nil {
+				t.Fatalf("http.NewRequest(): got error %v, want no error", err)
+			}
+
+			res := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     make(http.Header),
+				Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+				Request:    req,
+			}
+
+			ctx := &martian.Context{}
+			if err := mod.ModifyResponse(ctx, res); err != nil {
+				t.Fatalf("ModifyResponse(): got error %v, want no error", err)
+			}
+
+			if got := res.Header.Get("Content-Encoding"); got != tt.wantHdr {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.wantHdr)
+			}
+
+			raw, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("ioutil.ReadAll(res.Body): got error %v, want no error", err)
+			}
+
+			if got := decompress(t, tt.encoding, raw); string(got) != "hello world" {
+				t.Errorf("decoded body = %q, want %q", got, "hello world")
+			}
+
+			if res.ContentLength != int64(len(raw)) {
+				t.Errorf("res.ContentLength = %d, want %d", res.ContentLength, len(raw))
+			}
+		})
+	}
+}
+
+func TestModifyResponseEncodingAutoNegotiatesFromAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{name: "prefers brotli", acceptEncoding: "gzip, deflate, br", wantEncoding: encodingBrotli},
+		{name: "falls back to gzip", acceptEncoding: "gzip, deflate", wantEncoding: encodingGzip},
+		{name: "falls back to deflate", acceptEncoding: "deflate", wantEncoding: encodingDeflate},
+		{name: "falls back to identity", acceptEncoding: "", wantEncoding: encodingIdentity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mod, err := NewModifier([]byte("hello world"), "text/plain")
+			if err != nil {
+				t.Fatalf("NewModifier(): got error %v, want no error", err)
+			}
+			mod.scope = []parse.ModifierType{parse.Response}
+			mod.encoding = encodingAuto
+
+			req, err := http.NewRequest("GET", "http://example.com", nil)
+			if err != nil {
+				t.Fatalf("http.NewRequest(): got error %v, want no error", err)
+			}
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+
+			ctx := &martian.Context{}
+			if err := mod.ModifyRequest(ctx, req); err != nil {
+				t.Fatalf("ModifyRequest(): got error %v, want no error", err)
+			}
+
+			res := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     make(http.Header),
+				Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+				Request:    req,
+			}
+
+			if err := mod.ModifyResponse(ctx, res); err != nil {
+				t.Fatalf("ModifyResponse(): got error %v, want no error", err)
+			}
+
+			wantHdr := tt.wantEncoding
+			if wantHdr == encodingIdentity {
+				wantHdr = ""
+			}
+			if got := res.Header.Get("Content-Encoding"); got != wantHdr {
+				t.Errorf("Content-Encoding = %q, want %q", got, wantHdr)
+			}
+
+			raw, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("ioutil.ReadAll(res.Body): got error %v, want no error", err)
+			}
+
+			if got := decompress(t, tt.wantEncoding, raw); string(got) != "hello world" {
+				t.Errorf("decoded body = %q, want %q", got, "hello world")
+			}
+		})
+	}
+}
+
+func TestModifyResponseEncodingCachesCompressedVariant(t *testing.T) {
+	mod, err := NewModifier([]byte("hello world"), "text/plain")
+	if err != nil {
+		t.Fatalf("NewModifier(): got error %v, want no error", err)
+	}
+	mod.scope = []parse.ModifierType{parse.Response}
+	mod.encoding = encodingGzip
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got error %v, want no error", err)
+	}
+
+	newResponse := func() *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+			Request:    req,
+		}
+	}
+
+	ctx := &martian.Context{}
+	if err := mod.ModifyResponse(ctx, newResponse()); err != nil {
+		t.Fatalf("ModifyResponse() #1: got error %v, want no error", err)
+	}
+	if _, ok := mod.encCache[encodingGzip]; !ok {
+		t.Fatalf("encCache[%q] not populated after first ModifyResponse call", encodingGzip)
+	}
+
+	cached := mod.encCache[encodingGzip]
+
+	res := newResponse()
+	if err := mod.ModifyResponse(ctx, res); err != nil {
+		t.Fatalf("ModifyResponse() #2: got error %v, want no error", err)
+	}
+
+	raw, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(res.Body): got error %v, want no error", err)
+	}
+
+	if !bytes.Equal(raw, cached) {
+		t.Errorf("second call served %v, want cached bytes %v", raw, cached)
+	}
+}
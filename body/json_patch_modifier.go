@@ -0,0 +1,219 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package body
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/evanphx/json-patch"
+	"github.com/google/martian"
+	"github.com/google/martian/parse"
+)
+
+func init() {
+	parse.Register("body.JSONPatchModifier", jsonPatchModifierFromJSON)
+}
+
+// JSONPatchModifier rewrites a JSON response body in place by applying
+// either an RFC 6902 JSON Patch or an RFC 7396 JSON Merge Patch, rather
+// than replacing the body wholesale. Bodies whose Content-Type isn't
+// application/json (or a +json suffix) are left untouched.
+type JSONPatchModifier struct {
+	merge bool
+	patch []byte
+	scope []parse.ModifierType
+}
+
+// inScope reports whether t is one of the phases this modifier acts on. A
+// modifier with no scope set, as returned by NewJSONPatchModifier, acts on
+// responses only.
+func (m *JSONPatchModifier) inScope(t parse.ModifierType) bool {
+	if len(m.scope) == 0 {
+		return t == parse.Response
+	}
+	for _, s := range m.scope {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+type jsonPatchModifierJSON struct {
+	Mode  string               `json:"mode"` // "merge" or "patch"
+	Patch json.RawMessage      `json:"patch"`
+	Scope []parse.ModifierType `json:"scope"`
+}
+
+// NewJSONPatchModifier constructs and returns a JSONPatchModifier. mode must
+// be "merge" or "patch"; patch is the raw RFC 7396 merge document or RFC
+// 6902 patch document, respectively.
+func NewJSONPatchModifier(mode string, patch []byte) (*JSONPatchModifier, error) {
+	switch mode {
+	case "merge":
+		return &JSONPatchModifier{merge: true, patch: patch}, nil
+	case "patch":
+		return &JSONPatchModifier{merge: false, patch: patch}, nil
+	default:
+		return nil, fmt.Errorf("body: unknown JSON patch mode %q", mode)
+	}
+}
+
+// jsonPatchModifierFromJSON takes a JSON message as a byte slice and returns
+// a body.JSONPatchModifier and an error.
+//
+// Example JSON Configuration message:
+// {
+//   "scope": ["response"],
+//   "mode": "merge",
+//   "patch": {"error": null, "status": "ok"}
+// }
+func jsonPatchModifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &jsonPatchModifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	mod, err := NewJSONPatchModifier(msg.Mode, msg.Patch)
+	if err != nil {
+		return nil, err
+	}
+
+	mod.scope = msg.Scope
+
+	return parse.NewResult(mod, msg.Scope)
+}
+
+// ModifyRequest is a no-op; JSONPatchModifier only rewrites response bodies.
+func (m *JSONPatchModifier) ModifyRequest(ctx *martian.Context, req *http.Request) error {
+	return nil
+}
+
+// ModifyResponse applies the configured JSON patch or merge patch to the
+// response body, re-encoding it and fixing up Content-Length. Non-JSON
+// bodies are left untouched. Gzip/deflate encoded bodies are transparently
+// decoded before patching and re-encoded afterwards so that
+// Content-Encoding is preserved.
+func (m *JSONPatchModifier) ModifyResponse(ctx *martian.Context, res *http.Response) error {
+	if !m.inScope(parse.Response) {
+		return nil
+	}
+
+	if !isJSONContentType(res.Header.Get("Content-Type")) {
+		return nil
+	}
+
+	enc := res.Header.Get("Content-Encoding")
+
+	raw, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	doc, err := decodeBody(raw, enc)
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+	if m.merge {
+		patched, err = jsonpatch.MergePatch(doc, m.patch)
+	} else {
+		var p jsonpatch.Patch
+		p, err = jsonpatch.DecodePatch(m.patch)
+		if err == nil {
+			patched, err = p.Apply(doc)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	out, err := encodeBody(patched, enc)
+	if err != nil {
+		return err
+	}
+
+	res.Header.Set("Content-Length", fmt.Sprintf("%d", len(out)))
+	res.ContentLength = int64(len(out))
+	res.Body = ioutil.NopCloser(bytes.NewReader(out))
+
+	return nil
+}
+
+// isJSONContentType reports whether ct is "application/json" or carries a
+// "+json" structured syntax suffix, per RFC 6839.
+func isJSONContentType(ct string) bool {
+	ct = strings.TrimSpace(strings.SplitN(ct, ";", 2)[0])
+	return ct == "application/json" || strings.HasSuffix(ct, "+json")
+}
+
+// decodeBody decodes raw according to enc ("gzip", "deflate" or "").
+func decodeBody(raw []byte, enc string) ([]byte, error) {
+	switch enc {
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case "deflate":
+		r := flate.NewReader(bytes.NewReader(raw))
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	default:
+		return raw, nil
+	}
+}
+
+// encodeBody re-encodes raw according to enc, mirroring decodeBody.
+func encodeBody(raw []byte, enc string) ([]byte, error) {
+	switch enc {
+	case "gzip":
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(raw); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "deflate":
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(raw); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return raw, nil
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package body
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/google/martian"
+	"github.com/google/martian/parse"
+)
+
+func newJSONResponse(body []byte, contentType, contentEncoding string) *http.Response {
+	h := make(http.Header)
+	h.Set("Content-Type", contentType)
+	if contentEncoding != "" {
+		h.Set("Content-Encoding", contentEncoding)
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     h,
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+	}
+}
+
+func TestJSONPatchModifierMerge(t *testing.T) {
+	mod, err := NewJSONPatchModifier("merge", []byte(`{"status":"ok","error":null}`))
+	if err != nil {
+		t.Fatalf("NewJSONPatchModifier(): got error %v, want no error", err)
+	}
+	mod.scope = []parse.ModifierType{parse.Response}
+
+	res := newJSONResponse([]byte(`{"status":"fail","error":"boom"}`), "application/json", "")
+
+	ctx := &martian.Context{}
+	if err := mod.ModifyResponse(ctx, res); err != nil {
+		t.Fatalf("ModifyResponse(): got error %v, want no error", err)
+	}
+
+	raw, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(res.Body): got error %v, want no error", err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("json.Unmarshal(): got error %v, want no error", err)
+	}
+
+	if got["status"] != "ok" {
+		t.Errorf("status = %v, want %v", got["status"], "ok")
+	}
+	if _, ok := got["error"]; ok {
+		t.Errorf("error = %v, want key removed by null merge patch", got["error"])
+	}
+
+	if res.ContentLength != int64(len(raw)) {
+		t.Errorf("res.ContentLength = %d, want %d", res.ContentLength, len(raw))
+	}
+}
+
+func TestJSONPatchModifierPatch(t *testing.T) {
+	mod, err := NewJSONPatchModifier("patch", []byte(`[{"op":"replace","path":"/status","value":"ok"}]`))
+	if err != nil {
+		t.Fatalf("NewJSONPatchModifier(): got error %v, want no error", err)
+	}
+	mod.scope = []parse.ModifierType{parse.Response}
+
+	res := newJSONResponse([]byte(`{"status":"fail"}`), "application/json", "")
+
+	ctx := &martian.Context{}
+	if err := mod.ModifyResponse(ctx, res); err != nil {
+		t.Fatalf("ModifyResponse(): got error %v, want no error", err)
+	}
+
+	raw, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(res.Body): got error %v, want no error", err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("json.Unmarshal(): got error %v, want no error", err)
+	}
+
+	if got["status"] != "ok" {
+		t.Errorf("status = %v, want %v", got["status"], "ok")
+	}
+}
+
+func TestJSONPatchModifierGzipRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(`{"status":"fail"}`)); err != nil {
+		t.Fatalf("gzip Write(): got error %v, want no error", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("gzip Close(): got error %v, want no error", err)
+	}
+
+	mod, err := NewJSONPatchModifier("merge", []byte(`{"status":"ok"}`))
+	if err != nil {
+		t.Fatalf("NewJSONPatchModifier(): got error %v, want no error", err)
+	}
+	mod.scope = []parse.ModifierType{parse.Response}
+
+	res := newJSONResponse(buf.Bytes(), "application/json", "gzip")
+
+	ctx := &martian.Context{}
+	if err := mod.ModifyResponse(ctx, res); err != nil {
+		t.Fatalf("ModifyResponse(): got error %v, want no error", err)
+	}
+
+	if got := res.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want %q", got, "gzip")
+	}
+
+	raw, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(res.Body): got error %v, want no error", err)
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("gzip.NewReader(): got error %v, want no error", err)
+	}
+	defer r.Close()
+
+	decoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got error %v, want no error", err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(decoded, &got); err != nil {
+		t.Fatalf("json.Unmarshal(): got error %v, want no error", err)
+	}
+	if got["status"] != "ok" {
+		t.Errorf("status = %v, want %v", got["status"], "ok")
+	}
+}
+
+func TestJSONPatchModifierDeflateRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		t.Fatalf("flate.NewWriter(): got error %v, want no error", err)
+	}
+	if _, err := w.Write([]byte(`{"status":"fail"}`)); err != nil {
+		t.Fatalf("flate Write(): got error %v, want no error", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("flate Close(): got error %v, want no error", err)
+	}
+
+	mod, err := NewJSONPatchModifier("merge", []byte(`{"status":"ok"}`))
+	if err != nil {
+		t.Fatalf("NewJSONPatchModifier(): got error %v, want no error", err)
+	}
+	mod.scope = []parse.ModifierType{parse.Response}
+
+	res := newJSONResponse(buf.Bytes(), "application/json", "deflate")
+
+	ctx := &martian.Context{}
+	if err := mod.ModifyResponse(ctx, res); err != nil {
+		t.Fatalf("ModifyResponse(): got error %v, want no error", err)
+	}
+
+	raw, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(res.Body): got error %v, want no error", err)
+	}
+
+	r := flate.NewReader(bytes.NewReader(raw))
+	defer r.Close()
+
+	decoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got error %v, want no error", err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(decoded, &got); err != nil {
+		t.Fatalf("json.Unmarshal(): got error %v, want no error", err)
+	}
+	if got["status"] != "ok" {
+		t.Errorf("status = %v, want %v", got["status"], "ok")
+	}
+}
+
+func TestJSONPatchModifierSkipsNonJSON(t *testing.T) {
+	mod, err := NewJSONPatchModifier("merge", []byte(`{"status":"ok"}`))
+	if err != nil {
+		t.Fatalf("NewJSONPatchModifier(): got error %v, want no error", err)
+	}
+	mod.scope = []parse.ModifierType{parse.Response}
+
+	res := newJSONResponse([]byte("plain text"), "text/plain", "")
+
+	ctx := &martian.Context{}
+	if err := mod.ModifyResponse(ctx, res); err != nil {
+		t.Fatalf("ModifyResponse(): got error %v, want no error", err)
+	}
+
+	raw, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(res.Body): got error %v, want no error", err)
+	}
+	if string(raw) != "plain text" {
+		t.Errorf("body = %q, want untouched %q", raw, "plain text")
+	}
+}
+
+func TestJSONPatchModifierOutOfScope(t *testing.T) {
+	mod, err := NewJSONPatchModifier("merge", []byte(`{"status":"ok"}`))
+	if err != nil {
+		t.Fatalf("NewJSONPatchModifier(): got error %v, want no error", err)
+	}
+	mod.scope = []parse.ModifierType{parse.Request}
+
+	const body = `{"status":"fail"}`
+	res := newJSONResponse([]byte(body), "application/json", "")
+
+	ctx := &martian.Context{}
+	if err := mod.ModifyResponse(ctx, res); err != nil {
+		t.Fatalf("ModifyResponse(): got error %v, want no error", err)
+	}
+
+	raw, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(res.Body): got error %v, want no error", err)
+	}
+	if string(raw) != body {
+		t.Errorf("body = %q, want unchanged %q", raw, body)
+	}
+}
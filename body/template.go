@@ -0,0 +1,103 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package body
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+)
+
+// templateRequest is the .Request value exposed to a templated body.
+type templateRequest struct {
+	Method string
+	URL    *url.URL
+	Header http.Header
+}
+
+// templateResponse is the .Response value exposed to a templated body.
+type templateResponse struct {
+	StatusCode int
+	Header     http.Header
+}
+
+// templateContext is the root value passed to a templated body.
+//
+// TODO: expose the named capture groups of any upstream url.Matcher or
+// header.Matcher in scope as .Match; doing so requires those matchers to
+// surface their captures on martian.Context, which they don't yet do.
+type templateContext struct {
+	Request  templateRequest
+	Response templateResponse
+}
+
+// templateFuncs are the helper functions available to a templated body.
+var templateFuncs = template.FuncMap{
+	"uuid": func() string {
+		b := make([]byte, 16)
+		rand.Read(b)
+		b[6] = (b[6] & 0x0f) | 0x40
+		b[8] = (b[8] & 0x3f) | 0x80
+		return fmtUUID(b)
+	},
+	"now": func() time.Time {
+		return time.Now()
+	},
+	"randInt": func(min, max int) (int, error) {
+		if max <= min {
+			return 0, fmt.Errorf("body: randInt: max (%d) must be greater than min (%d)", max, min)
+		}
+		return min + rand.Intn(max-min), nil
+	},
+	"base64": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"jsonEscape": func(s string) (string, error) {
+		b, err := json.Marshal(s)
+		if err != nil {
+			return "", err
+		}
+		// Marshal wraps the string in quotes; strip them so the helper can
+		// be used inline inside an existing JSON string literal.
+		return string(b[1 : len(b)-1]), nil
+	},
+}
+
+// fmtUUID formats a 16-byte slice as a canonical UUID string.
+func fmtUUID(b []byte) string {
+	const hex = "0123456789abcdef"
+	buf := make([]byte, 36)
+	dashes := map[int]bool{8: true, 13: true, 18: true, 23: true}
+	j := 0
+	for i := 0; i < 36; i++ {
+		if dashes[i] {
+			buf[i] = '-'
+			continue
+		}
+		v := b[j/2]
+		if j%2 == 0 {
+			buf[i] = hex[v>>4]
+		} else {
+			buf[i] = hex[v&0x0f]
+		}
+		j++
+	}
+	return string(buf)
+}
@@ -0,0 +1,136 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package body
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/google/martian"
+	"github.com/google/martian/parse"
+)
+
+func newTemplateResponse(req *http.Request, statusCode int) *http.Response {
+	return &http.Response{
+		StatusCode: statusCode,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(nil),
+		Request:    req,
+	}
+}
+
+func TestTemplateModifierRendersRequestAndResponseContext(t *testing.T) {
+	mod, err := NewTemplateModifier([]byte(`{"method":"{{.Request.Method}}","status":{{.Response.StatusCode}}}`), "application/json")
+	if err != nil {
+		t.Fatalf("NewTemplateModifier(): got error %v, want no error", err)
+	}
+	mod.scope = []parse.ModifierType{parse.Response}
+
+	req, err := http.NewRequest("POST", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got error %v, want no error", err)
+	}
+
+	res := newTemplateResponse(req, http.StatusTeapot)
+
+	ctx := &martian.Context{}
+	if err := mod.ModifyResponse(ctx, res); err != nil {
+		t.Fatalf("ModifyResponse(): got error %v, want no error", err)
+	}
+
+	raw, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(res.Body): got error %v, want no error", err)
+	}
+
+	if want := `{"method":"POST","status":418}`; string(raw) != want {
+		t.Errorf("body = %q, want %q", raw, want)
+	}
+
+	if res.ContentLength != int64(len(raw)) {
+		t.Errorf("res.ContentLength = %d, want %d", res.ContentLength, len(raw))
+	}
+}
+
+func TestTemplateModifierHelperFuncs(t *testing.T) {
+	mod, err := NewTemplateModifier([]byte(`{"id":"{{uuid}}","b64":"{{base64 "hi"}}","esc":"{{jsonEscape "a\"b"}}"}`), "application/json")
+	if err != nil {
+		t.Fatalf("NewTemplateModifier(): got error %v, want no error", err)
+	}
+	mod.scope = []parse.ModifierType{parse.Response}
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got error %v, want no error", err)
+	}
+
+	res := newTemplateResponse(req, http.StatusOK)
+
+	ctx := &martian.Context{}
+	if err := mod.ModifyResponse(ctx, res); err != nil {
+		t.Fatalf("ModifyResponse(): got error %v, want no error", err)
+	}
+
+	raw, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(res.Body): got error %v, want no error", err)
+	}
+
+	var got struct {
+		ID  string `json:"id"`
+		B64 string `json:"b64"`
+		Esc string `json:"esc"`
+	}
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("json.Unmarshal(%s): got error %v, want no error", raw, err)
+	}
+
+	uuidRE := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !uuidRE.MatchString(got.ID) {
+		t.Errorf("uuid = %q, want a v4 UUID", got.ID)
+	}
+
+	if want := base64.StdEncoding.EncodeToString([]byte("hi")); got.B64 != want {
+		t.Errorf("base64 = %q, want %q", got.B64, want)
+	}
+
+	if want := `a"b`; got.Esc != want {
+		t.Errorf("jsonEscape = %q, want %q", got.Esc, want)
+	}
+}
+
+func TestTemplateModifierRandIntGuardsRange(t *testing.T) {
+	mod, err := NewTemplateModifier([]byte(`{{randInt 5 5}}`), "text/plain")
+	if err != nil {
+		t.Fatalf("NewTemplateModifier(): got error %v, want no error", err)
+	}
+	mod.scope = []parse.ModifierType{parse.Response}
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got error %v, want no error", err)
+	}
+
+	res := newTemplateResponse(req, http.StatusOK)
+
+	ctx := &martian.Context{}
+	if err := mod.ModifyResponse(ctx, res); err == nil {
+		t.Fatal("ModifyResponse(): got no error, want error for randInt with max <= min")
+	}
+}